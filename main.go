@@ -1,16 +1,13 @@
 package main
 
 import (
-	"fmt"
 	"os"
 
 	"github.com/spotlightpa/sheets-uploader/sheets"
 )
 
 func main() {
-	c := sheets.FromArgs(os.Args[1:])
-	if err := c.Exec(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	if err := sheets.CLI(os.Args[1:]); err != nil {
 		os.Exit(1)
 	}
 }
@@ -5,28 +5,28 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/base64"
-	"encoding/csv"
 	"flag"
 	"fmt"
 	"hash"
 	"html/template"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
-	"net/url"
 	"os"
 	"path"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/cloudfront"
 	"github.com/carlmjohnson/flagext"
 	"github.com/henvic/ctxsignal"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gocloud.dev/blob"
 	_ "gocloud.dev/blob/fileblob"
 	_ "gocloud.dev/blob/memblob"
 	"golang.org/x/oauth2/google"
-	spreadsheet "gopkg.in/Iwark/spreadsheet.v2"
+	"google.golang.org/api/option"
+	sheetsv4 "google.golang.org/api/sheets/v4"
 )
 
 const AppName = "sheets-uploader"
@@ -36,6 +36,14 @@ func CLI(args []string) error {
 	if err := conf.FromArgs(args); err != nil {
 		return err
 	}
+
+	shutdown, err := initTracing(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %+v\n", err)
+		return err
+	}
+	defer shutdown(context.Background())
+
 	if err := conf.Exec(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %+v\n", err)
 		return err
@@ -47,6 +55,8 @@ func (conf *Config) FromArgs(args []string) error {
 	fl := flag.NewFlagSet(AppName, flag.ExitOnError)
 	fl.IntVar(&conf.NWorkers, "workers", 10, "number of upload workers")
 	fl.StringVar(&conf.SheetID, "sheet", "", "Google Sheet ID")
+	fl.StringVar(&conf.Mode, "mode", ModePull,
+		fmt.Sprintf("sync `direction`: %q to save the Sheet to the bucket, %q to write bucket CSVs back to the Sheet", ModePull, ModePush))
 	flagext.Callback(fl, "google-client-secret", "", "`base64 encoded JSON` of Google client secret",
 		func(s string) error {
 			var err error
@@ -56,24 +66,93 @@ func (conf *Config) FromArgs(args []string) error {
 	fl.StringVar(&conf.PathTemplate, "path", "{{.Properties.Title}}", "path to save files in")
 	fl.StringVar(&conf.FileTemplate, "filename", "{{.Properties.Index}} {{.Properties.Title}}.csv",
 		"file name for files")
+	fl.Var(&formatsFlag{&conf.Formats}, "format",
+		fmt.Sprintf("output `format` (one of %v); may be repeated to write a file per format", formatterNames))
 	fl.StringVar(&conf.BucketURL, "bucket-url", "file://.",
 		"`URL` for destination bucket")
 	fl.StringVar(&conf.CloudFrontDist, "dist", "",
-		"`distibution ID` for AWS CloudFront CDN invalidation")
+		"`distibution ID` for AWS CloudFront CDN invalidation; shorthand for -cdn cloudfront://<id>")
+	fl.Var(&cdnsFlag{&conf.CDNs}, "cdn",
+		"`URL` of a CDN to invalidate after upload, e.g. fastly://service-id?token=..., "+
+			"cloudflare://zone-id?token=...&origin=https://example.com, or gccdn://url-map?project=...&token=...; may be repeated")
+
+	fl.StringVar(&conf.SheetFilter, "sheets", "",
+		"glob, or `re:`-prefixed regex, matching sheet titles to include; default includes all sheets")
+	fl.BoolVar(&conf.OnlyVisible, "only-visible", false, "skip sheets where Properties.Hidden is true")
+	fl.StringVar(&conf.RangeClip, "range", "",
+		"A1-notation `range` to clip each sheet to, e.g. B2:F")
+	fl.IntVar(&conf.HeaderRow, "header-row", 0,
+		"row `index` (0-based, applied after -range) to use as the header; becomes row 0 of the output")
+	fl.IntVar(&conf.SkipRows, "skip-rows", 0,
+		"number of rows to drop immediately after the header row")
+	fl.StringVar(&conf.ValueRender, "value-render", RenderFormatted,
+		fmt.Sprintf("how to render cell values: %q, %q, or %q", RenderFormatted, RenderUnformatted, RenderFormula))
 
 	fl.StringVar(&conf.CacheControl, "cache-control", "max-age=900,public",
 		"`value` for Cache-Control header")
 	fl.BoolVar(&conf.UseCRLF, "crlf", false, "use Windows-style line endings")
+	fl.StringVar(&conf.Manifest, "manifest", "",
+		"`path`, relative to -path, to write an index.json manifest of every file written; disabled if empty")
 
-	conf.Logger = log.New(os.Stderr, AppName+" ", log.LstdFlags)
-	flagext.LoggerVar(fl, conf.Logger, "quiet", flagext.LogSilent,
-		"don't log activity")
+	fl.BoolVar(&conf.Quiet, "quiet", false, "don't log activity")
+	fl.StringVar(&conf.LogFormat, "log-format", LogFormatText,
+		fmt.Sprintf("log output `format`: %q or %q", LogFormatText, LogFormatJSON))
 	fl.Usage = func() {
 		fmt.Fprintf(os.Stderr,
 			`sheets-uploader is a tool to save all sheets in Google Sheets document to cloud storage.
 
+-mode controls the direction of sync: "pull" (the default) saves the Sheet to
+the bucket as CSV; "push" reads the CSVs already saved in the bucket and
+writes any that have changed back to the Sheet.
+
 -path and -filename are Go templates and can use any property of the document
-or sheet object respectively. See gopkg.in/Iwark/spreadsheet.v2 for properties.
+or sheet object respectively. See google.golang.org/api/sheets/v4 Spreadsheet
+and Sheet for properties.
+
+-format selects the output format(s) written per sheet; pass it more than
+once to write the same sheet in multiple formats. The first row of each
+sheet is treated as a header when keying JSON, NDJSON, and Parquet output.
+JSON and NDJSON rows also carry a "_meta" object keyed by column name for any
+cell that has a hyperlink or note.
+
+-value-render controls which form of a cell's value is used: "formatted" is
+what's displayed in the Sheets UI, "unformatted" is the computed value with
+no number formatting applied, and "formula" is the formula text itself where
+a cell has one.
+
+-cdn may be repeated to invalidate more than one CDN after upload; all are
+invalidated in parallel. -dist is kept as shorthand for -cdn cloudfront://.
+
+cloudflare:// requires an ?origin=https://example.com query param: unlike
+the other CDNs, Cloudflare's purge_cache API takes fully-qualified URLs, not
+bucket-relative paths, and this tool has no other way to know the domain
+files are served from.
+
+fastly:// purges by surrogate key using each file's bucket-relative path as
+the key. Every uploaded file is tagged with that same path in a
+"surrogate-key" metadata entry, but cloud storage metadata isn't itself an
+HTTP response header: the CDN's origin must be configured to mirror it into
+a Surrogate-Key response header (e.g. an S3 origin forwarding its
+x-amz-meta-surrogate-key) for these purges to match anything being served.
+
+-sheets, -only-visible, -range, -header-row, and -skip-rows control which
+sheets are exported and which of their cells end up in the output: -sheets
+and -only-visible filter out whole sheets; -range, -header-row, and
+-skip-rows reshape the remaining sheets' rows before they're formatted.
+
+-manifest, if set, writes an index.json alongside the uploaded files listing
+every file written this run: its sheet, path, byte size, MD5, row/column
+counts, and inferred column schema. It's included in CDN invalidation like
+any other file. In -mode push, it's also how a brand new tab gets created:
+a CSV's filename can't generally be inverted back through -filename's
+template, so any sheet the manifest lists that the Spreadsheet doesn't
+already have gets added and populated from its bucket CSV.
+
+-log-format selects "text" (the default) or "json" structured log output.
+
+If the OTEL_EXPORTER_OTLP_ENDPOINT environment variable is set, sheets-uploader
+exports OpenTelemetry traces for the Sheets fetch, each sheet upload, and CDN
+invalidation to that collector over OTLP/gRPC.
 
 If -google-client-secret is not specified, the default Google credentials will be used:
 
@@ -112,26 +191,98 @@ Usage of sheets-uploader:
 		return err
 	}
 
+	if len(conf.Formats) == 0 {
+		conf.Formats = []string{"csv"}
+	}
+
+	switch conf.Mode {
+	case ModePull, ModePush:
+	default:
+		return fmt.Errorf("unknown -mode %q", conf.Mode)
+	}
+
+	switch conf.ValueRender {
+	case RenderFormatted, RenderUnformatted, RenderFormula:
+	default:
+		return fmt.Errorf("unknown -value-render %q", conf.ValueRender)
+	}
+
+	w := io.Writer(os.Stderr)
+	if conf.Quiet {
+		w = io.Discard
+	}
+	var handler slog.Handler
+	switch conf.LogFormat {
+	case LogFormatJSON:
+		handler = slog.NewJSONHandler(w, nil)
+	case LogFormatText:
+		handler = slog.NewTextHandler(w, nil)
+	default:
+		return fmt.Errorf("unknown -log-format %q", conf.LogFormat)
+	}
+	conf.Logger = slog.New(handler)
+
 	return nil
 }
 
+// Mode values for Config.Mode, selecting which direction Exec syncs data.
+const (
+	// ModePull saves the Google Sheet to the bucket as CSV (the original,
+	// default behavior).
+	ModePull = "pull"
+	// ModePush reads CSVs from the bucket and writes them back to the
+	// Google Sheet.
+	ModePush = "push"
+)
+
+// Log format values for Config.LogFormat, selecting -log-format.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
 type Config struct {
 	NWorkers           int
 	SheetID            string
+	Mode               string
 	GoogleClientSecret []byte
 	PathTemplate       string
 	FileTemplate       string
+	Formats            []string
 	BucketURL          string
 	CacheControl       string
 	UseCRLF            bool
 	CloudFrontDist     string
-	Logger             *log.Logger
+	CDNs               []string
+	SheetFilter        string
+	OnlyVisible        bool
+	RangeClip          string
+	HeaderRow          int
+	SkipRows           int
+	ValueRender        string
+	Manifest           string
+	Quiet              bool
+	LogFormat          string
+	Logger             *slog.Logger
 }
 
 func (c *Config) Exec() (err error) {
+	ctx, span := tracer.Start(context.Background(), "Exec", trace.WithAttributes(
+		attribute.String("sheet_id", c.SheetID),
+		attribute.String("mode", c.Mode),
+	))
+	defer endSpan(span, &err)
+
 	if c.NWorkers < 1 {
 		return fmt.Errorf("invalid number of workers: %d", c.NWorkers)
 	}
+	if c.Mode == ModePush {
+		return c.execPush(ctx)
+	}
+	return c.execPull(ctx)
+}
+
+func (c *Config) execPull(ctx context.Context) (err error) {
 
 	pt, err := template.New("path").Parse(c.PathTemplate)
 	if err != nil {
@@ -142,10 +293,10 @@ func (c *Config) Exec() (err error) {
 		return fmt.Errorf("file path template problem: %v", err)
 	}
 
-	ctx, cancel := ctxsignal.WithTermination(context.Background())
+	ctx, cancel := ctxsignal.WithTermination(ctx)
 	defer cancel()
 
-	c.Logger.Printf("opening cloud storage %q", c.BucketURL)
+	c.Logger.Info("opening cloud storage", "bucket_url", c.BucketURL)
 	b, err := blob.OpenBucket(ctx, c.BucketURL)
 	if err != nil {
 		return fmt.Errorf("could not open bucket: %v", err)
@@ -157,14 +308,22 @@ func (c *Config) Exec() (err error) {
 		return err
 	}
 
-	c.Logger.Printf("connecting to Google Sheets for %q", c.SheetID)
-	service := spreadsheet.NewServiceWithClient(client)
-	doc, err := service.FetchSpreadsheet(c.SheetID)
+	c.Logger.Info("connecting to Google Sheets", "sheet_id", c.SheetID)
+	svc, err := sheetsv4.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return fmt.Errorf("could not start Google Sheets client: %v", err)
+	}
+	doc, err := c.fetchSpreadsheet(ctx, svc)
 	if err != nil {
-		return fmt.Errorf("failure getting Google Sheet: %v", err)
+		return err
 	}
 
-	c.Logger.Printf("got %q", doc.Properties.Title)
+	c.Logger.Info("fetched spreadsheet", "sheet_id", c.SheetID, "sheet_title", doc.Properties.Title)
+
+	doc.Sheets, err = c.filterSheets(doc.Sheets)
+	if err != nil {
+		return err
+	}
 
 	var dirBuf strings.Builder
 	if err = pt.Execute(&dirBuf, doc); err != nil {
@@ -172,20 +331,21 @@ func (c *Config) Exec() (err error) {
 	}
 	dir := dirBuf.String()
 
-	c.Logger.Printf("%d upload workers", c.NWorkers)
+	c.Logger.Info("starting upload workers", "workers", c.NWorkers)
 	type result struct {
-		path string
-		err  error
+		paths   []string
+		entries []ManifestEntry
+		err     error
 	}
 	var (
-		sheetCh   = make(chan *spreadsheet.Sheet)
+		sheetCh   = make(chan *sheetsv4.Sheet)
 		resultCh  = make(chan result)
 		waitingOn = 0
 		opts      = blob.WriterOptions{
 			CacheControl: c.CacheControl,
-			ContentType:  "text/csv",
 		}
-		paths []string
+		paths   []string
+		entries []ManifestEntry
 	)
 	for i := 0; i < c.NWorkers; i++ {
 		go func() {
@@ -200,9 +360,9 @@ func (c *Config) Exec() (err error) {
 					if !ok {
 						return
 					}
-					fullpath, err := c.uploadSheet(
+					changed, sheetEntries, err := c.uploadSheet(
 						ctx, b, &sb, &buf, ft, s, dir, h, &opts)
-					resultCh <- result{fullpath, err}
+					resultCh <- result{changed, sheetEntries, err}
 				case <-ctx.Done():
 					return
 				}
@@ -211,9 +371,9 @@ func (c *Config) Exec() (err error) {
 	}
 	for len(doc.Sheets) > 0 || waitingOn > 0 {
 		workCh := sheetCh
-		var sheet *spreadsheet.Sheet
+		var sheet *sheetsv4.Sheet
 		if len(doc.Sheets) > 0 {
-			sheet = &doc.Sheets[0]
+			sheet = doc.Sheets[0]
 		} else {
 			workCh = nil
 		}
@@ -226,91 +386,141 @@ func (c *Config) Exec() (err error) {
 			if res.err != nil {
 				return res.err
 			}
-			if res.path != "" {
-				paths = append(paths, res.path)
-			}
+			paths = append(paths, res.paths...)
+			entries = append(entries, res.entries...)
 		}
 	}
-	if len(paths) > 0 && c.CloudFrontDist != "" {
-		_, err = c.invalidate(paths)
-		return err
+
+	if c.Manifest != "" {
+		manifestPath, err := c.writeManifest(ctx, b, dir, Manifest{
+			Title:       doc.Properties.Title,
+			SheetID:     c.SheetID,
+			GeneratedAt: time.Now().UTC(),
+			Sheets:      entries,
+		}, &opts)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, manifestPath)
+	}
+
+	if len(paths) > 0 {
+		return c.invalidateAll(ctx, paths)
 	}
 	return nil
 }
 
 func (c *Config) googleClient(ctx context.Context) (*http.Client, error) {
 	if len(c.GoogleClientSecret) > 0 {
-		c.Logger.Printf("using base64 Google credentials")
-		conf, err := google.JWTConfigFromJSON(c.GoogleClientSecret, spreadsheet.Scope)
+		c.Logger.Info("using base64 Google credentials")
+		conf, err := google.JWTConfigFromJSON(c.GoogleClientSecret, sheetsv4.SpreadsheetsScope)
 		if err != nil {
 			return nil, fmt.Errorf("could not parse Google credentials: %v", err)
 		}
 		return conf.Client(ctx), nil
 	}
-	c.Logger.Printf("using default Google credentials")
-	client, err := google.DefaultClient(ctx, spreadsheet.Scope)
+	c.Logger.Info("using default Google credentials")
+	client, err := google.DefaultClient(ctx, sheetsv4.SpreadsheetsScope)
 	if err != nil {
 		return nil, fmt.Errorf("could not find Google credentials: %v", err)
 	}
 	return client, nil
 }
 
-func (c *Config) uploadSheet(ctx context.Context, b *blob.Bucket, sb *strings.Builder, buf *bytes.Buffer, ft *template.Template, s *spreadsheet.Sheet, dir string, h hash.Hash, opts *blob.WriterOptions) (fullpath string, err error) {
+// fetchSpreadsheet fetches c.SheetID with grid data included, wrapped in its
+// own span since it's usually the slowest single call in a run.
+func (c *Config) fetchSpreadsheet(ctx context.Context, svc *sheetsv4.Service) (doc *sheetsv4.Spreadsheet, err error) {
+	ctx, span := tracer.Start(ctx, "FetchSpreadsheet", trace.WithAttributes(
+		attribute.String("sheet_id", c.SheetID),
+	))
+	defer endSpan(span, &err)
+
+	doc, err = svc.Spreadsheets.Get(c.SheetID).
+		IncludeGridData(true).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return nil, fmt.Errorf("failure getting Google Sheet: %v", err)
+	}
+	return doc, nil
+}
+
+// uploadSheet writes s to the bucket once per format in c.Formats, skipping
+// any format whose rendering is unchanged from what's already stored. It
+// returns the full paths of the files that were written, for CDN
+// invalidation.
+func (c *Config) uploadSheet(ctx context.Context, b *blob.Bucket, sb *strings.Builder, buf *bytes.Buffer, ft *template.Template, s *sheetsv4.Sheet, dir string, h hash.Hash, opts *blob.WriterOptions) (changed []string, entries []ManifestEntry, err error) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "uploadSheet", trace.WithAttributes(
+		attribute.String("sheet_id", c.SheetID),
+		attribute.String("sheet_title", s.Properties.Title),
+	))
+	defer endSpan(span, &err)
+	defer func() {
+		c.Logger.Info("uploaded sheet",
+			"sheet_id", c.SheetID,
+			"sheet_title", s.Properties.Title,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"error", err,
+		)
+	}()
+
 	sb.Reset()
 	if err = ft.Execute(sb, s); err != nil {
-		return "", fmt.Errorf("could not use file path template: %v", err)
+		return nil, nil, fmt.Errorf("could not use file path template: %v", err)
 	}
 	file := sb.String()
+	base := strings.TrimSuffix(file, path.Ext(file))
 
-	if err = c.makeCSV(buf, s.Rows); err != nil {
-		return "", err
+	rows, err := c.clipSheet(flattenGrid(s.Data))
+	if err != nil {
+		return nil, nil, err
 	}
+	records := cellsFromRows(rows, c.ValueRender)
+	rowCount, colCount, schema := sheetSchema(records)
 
-	fullpath = path.Join(dir, file)
-	var returnPath string
-	c.Logger.Printf("checking existing %q in %q", fullpath, c.BucketURL)
-	attrs, err := b.Attributes(ctx, fullpath)
-	if err == nil && attrs.MD5 != nil {
-		// Get checksum
+	for _, name := range c.Formats {
+		f, err := formatterFor(name, c.UseCRLF)
+		if err != nil {
+			return changed, entries, err
+		}
+
+		buf.Reset()
+		if err = f.Format(buf, records); err != nil {
+			return changed, entries, err
+		}
+
+		fullpath := path.Join(dir, base+f.Ext())
 		h.Reset()
 		if _, err := h.Write(buf.Bytes()); err != nil {
-			return "", err
+			return changed, entries, err
 		}
-		if string(h.Sum(nil)) == string(attrs.MD5) {
-			c.Logger.Printf("skipping %q; already uploaded", fullpath)
-			return "", nil
+		sum := h.Sum(nil)
+
+		attrs, err := b.Attributes(ctx, fullpath)
+		if err == nil && attrs.MD5 != nil {
+			if string(sum) == string(attrs.MD5) {
+				c.Logger.Info("skipping unchanged file", "path", fullpath, "bytes", buf.Len(), "md5", md5Hex(sum), "skipped", true)
+				if c.Manifest != "" {
+					entries = append(entries, newManifestEntry(s, fullpath, buf.Len(), sum, rowCount, colCount, schema))
+				}
+				continue
+			}
+			changed = append(changed, fullpath)
 		}
-		returnPath = fullpath
-	}
 
-	c.Logger.Printf("writing %q to %q", fullpath, c.BucketURL)
-	if err = b.WriteAll(ctx, fullpath, buf.Bytes(), opts); err != nil {
-		return "", err
-	}
-	return returnPath, nil
-}
-
-func (c *Config) makeCSV(buf *bytes.Buffer, rows [][]spreadsheet.Cell) (err error) {
-	buf.Reset()
-	w := csv.NewWriter(buf)
-	w.UseCRLF = c.UseCRLF
-	defer w.Flush()
-	defer deferClose(&err, w.Error)
-
-	for _, row := range rows {
-		record := make([]string, 0, len(row))
-		for _, cell := range row {
-			record = append(record, cell.Value)
+		writeOpts := *opts
+		writeOpts.ContentType = f.ContentType()
+		writeOpts.Metadata = surrogateKeyMetadata(fullpath)
+		c.Logger.Info("writing file", "path", fullpath, "bytes", buf.Len(), "md5", md5Hex(sum), "skipped", false)
+		if err = b.WriteAll(ctx, fullpath, buf.Bytes(), &writeOpts); err != nil {
+			return changed, entries, err
 		}
-		if blank(record) {
-			continue
-		}
-		err = w.Write(record)
-		if err != nil {
-			return err
+		if c.Manifest != "" {
+			entries = append(entries, newManifestEntry(s, fullpath, buf.Len(), sum, rowCount, colCount, schema))
 		}
 	}
-	return nil
+	return changed, entries, nil
 }
 
 func blank(record []string) bool {
@@ -328,43 +538,3 @@ func deferClose(err *error, f func() error) {
 		*err = fmt.Errorf("problem closing: %v", newErr)
 	}
 }
-
-func makepaths(paths []string) *cloudfront.Paths {
-	items := make([]*string, len(paths))
-	for i := range paths {
-		items[i] = &paths[i]
-	}
-	quantity := int64(len(items))
-	return &cloudfront.Paths{
-		Items:    items,
-		Quantity: &quantity,
-	}
-}
-
-func (c *Config) invalidate(paths []string) (id string, err error) {
-	for i, path := range paths {
-		if !strings.HasPrefix(path, "/") {
-			path = "/" + path
-		}
-		path = url.PathEscape(path)
-		path = strings.ReplaceAll(path, "%2F", "/")
-		paths[i] = path
-	}
-	c.Logger.Printf("invalidating %v in CloudFront %s", paths, c.CloudFrontDist)
-
-	cf := cloudfront.New(session.Must(session.NewSession()))
-	callerReference := time.Now().Format("20060102150405")
-
-	result, err := cf.CreateInvalidation(&cloudfront.CreateInvalidationInput{
-		DistributionId: &c.CloudFrontDist,
-		InvalidationBatch: &cloudfront.InvalidationBatch{
-			CallerReference: &callerReference,
-			Paths:           makepaths(paths),
-		},
-	})
-	if err != nil {
-		return "", err
-	}
-
-	return *result.Invalidation.Id, nil
-}
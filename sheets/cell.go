@@ -0,0 +1,111 @@
+package sheets
+
+import (
+	"strconv"
+
+	sheetsv4 "google.golang.org/api/sheets/v4"
+)
+
+// Value-render modes for -value-render, controlling which representation of
+// a cell's value ends up in the rendered output.
+const (
+	RenderFormatted   = "formatted"
+	RenderUnformatted = "unformatted"
+	RenderFormula     = "formula"
+)
+
+// Cell is a single exported cell: its rendered value, plus any hyperlink or
+// note attached to it in the source Sheet.
+type Cell struct {
+	Value     string
+	Hyperlink string
+	Note      string
+}
+
+// Blank reports whether the cell has no value, hyperlink, or note worth
+// keeping.
+func (c Cell) Blank() bool {
+	return c.Value == "" && c.Hyperlink == "" && c.Note == ""
+}
+
+// flattenGrid flattens a sheet's grid data blocks into a single slice of
+// rows, the shape clipSheet and the Formatters expect.
+func flattenGrid(data []*sheetsv4.GridData) [][]*sheetsv4.CellData {
+	var rows [][]*sheetsv4.CellData
+	for _, block := range data {
+		for _, row := range block.RowData {
+			rows = append(rows, row.Values)
+		}
+	}
+	return rows
+}
+
+// cellsFromRows converts a sheet's raw cells to Cells using mode, dropping
+// any row that is entirely blank.
+func cellsFromRows(rows [][]*sheetsv4.CellData, mode string) [][]Cell {
+	out := make([][]Cell, 0, len(rows))
+	for _, row := range rows {
+		record := make([]Cell, 0, len(row))
+		for _, cell := range row {
+			record = append(record, Cell{
+				Value:     cellValue(cell, mode),
+				Hyperlink: cell.Hyperlink,
+				Note:      cell.Note,
+			})
+		}
+		if blankRow(record) {
+			continue
+		}
+		out = append(out, record)
+	}
+	return out
+}
+
+func blankRow(row []Cell) bool {
+	for _, c := range row {
+		if !c.Blank() {
+			return false
+		}
+	}
+	return true
+}
+
+// cellValue extracts cell's value according to mode: "formatted" is what's
+// displayed in the Sheets UI (the default), "unformatted" is the computed
+// value with no number formatting applied, and "formula" is the formula
+// text itself, falling back to the formatted value for cells with none.
+func cellValue(cell *sheetsv4.CellData, mode string) string {
+	if cell == nil {
+		return ""
+	}
+	switch mode {
+	case RenderUnformatted:
+		return extendedValueString(cell.EffectiveValue)
+	case RenderFormula:
+		if cell.UserEnteredValue != nil && cell.UserEnteredValue.FormulaValue != nil {
+			return *cell.UserEnteredValue.FormulaValue
+		}
+		return cell.FormattedValue
+	default:
+		return cell.FormattedValue
+	}
+}
+
+// extendedValueString renders an ExtendedValue as a plain string, without
+// any number formatting.
+func extendedValueString(v *sheetsv4.ExtendedValue) string {
+	switch {
+	case v == nil:
+		return ""
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.NumberValue != nil:
+		return strconv.FormatFloat(*v.NumberValue, 'g', -1, 64)
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.FormulaValue != nil:
+		return *v.FormulaValue
+	default:
+		return ""
+	}
+}
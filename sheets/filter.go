@@ -0,0 +1,163 @@
+package sheets
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	sheetsv4 "google.golang.org/api/sheets/v4"
+)
+
+// filterSheets drops sheets that shouldn't be exported: hidden sheets when
+// OnlyVisible is set, and any sheet whose title doesn't match SheetFilter.
+func (c *Config) filterSheets(sheets []*sheetsv4.Sheet) ([]*sheetsv4.Sheet, error) {
+	out := sheets[:0]
+	for _, s := range sheets {
+		if c.OnlyVisible && s.Properties.Hidden {
+			continue
+		}
+		ok, err := matchesSheetFilter(s.Properties.Title, c.SheetFilter)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// matchesSheetFilter reports whether title matches pattern. An empty
+// pattern matches everything. A "re:" prefix selects a regular expression;
+// otherwise pattern is a path.Match glob.
+func matchesSheetFilter(title, pattern string) (bool, error) {
+	if pattern == "" {
+		return true, nil
+	}
+	if re := strings.TrimPrefix(pattern, "re:"); re != pattern {
+		rx, err := regexp.Compile(re)
+		if err != nil {
+			return false, fmt.Errorf("invalid -sheets regex %q: %v", pattern, err)
+		}
+		return rx.MatchString(title), nil
+	}
+	ok, err := path.Match(pattern, title)
+	if err != nil {
+		return false, fmt.Errorf("invalid -sheets glob %q: %v", pattern, err)
+	}
+	return ok, nil
+}
+
+// clipSheet applies -range, -header-row, and -skip-rows to a sheet's raw
+// cells, in that order: -range clips to the requested rectangle, then the
+// row at -header-row is pulled out and becomes row 0, then -skip-rows drops
+// that many rows immediately below it.
+func (c *Config) clipSheet(rows [][]*sheetsv4.CellData) ([][]*sheetsv4.CellData, error) {
+	rowStart, colStart, rowEnd, colEnd, err := parseA1Range(c.RangeClip)
+	if err != nil {
+		return nil, err
+	}
+	rows = clipCells(rows, rowStart, colStart, rowEnd, colEnd)
+	return applyHeaderSkip(rows, c.HeaderRow, c.SkipRows)
+}
+
+var a1RangeRe = regexp.MustCompile(`^([A-Za-z]*)(\d*):([A-Za-z]*)(\d*)$`)
+
+// parseA1Range parses an A1-notation clip like "B2:F". Missing bounds are
+// left open: colEnd and rowEnd of -1 mean "through the last column/row".
+func parseA1Range(spec string) (rowStart, colStart, rowEnd, colEnd int, err error) {
+	rowStart, colStart, rowEnd, colEnd = 0, 0, -1, -1
+	if spec == "" {
+		return
+	}
+	m := a1RangeRe.FindStringSubmatch(spec)
+	if m == nil {
+		return 0, 0, -1, -1, fmt.Errorf("invalid -range %q: must be A1-notation like B2:F", spec)
+	}
+	if m[1] != "" {
+		colStart = colLetterIndex(m[1])
+	}
+	if m[2] != "" {
+		n, _ := strconv.Atoi(m[2])
+		rowStart = n - 1
+	}
+	if m[3] != "" {
+		colEnd = colLetterIndex(m[3]) + 1
+	}
+	if m[4] != "" {
+		n, _ := strconv.Atoi(m[4])
+		rowEnd = n
+	}
+	return
+}
+
+// colLetterIndex converts a spreadsheet column reference (A, B, ..., Z, AA,
+// ...) to a 0-based index.
+func colLetterIndex(letters string) int {
+	n := 0
+	for _, r := range strings.ToUpper(letters) {
+		n = n*26 + int(r-'A'+1)
+	}
+	return n - 1
+}
+
+func clipCells(rows [][]*sheetsv4.CellData, rowStart, colStart, rowEnd, colEnd int) [][]*sheetsv4.CellData {
+	if rowStart > len(rows) {
+		rowStart = len(rows)
+	}
+	end := len(rows)
+	if rowEnd >= 0 && rowEnd < end {
+		end = rowEnd
+	}
+	if end < rowStart {
+		end = rowStart
+	}
+	clipped := rows[rowStart:end]
+
+	if colStart == 0 && colEnd < 0 {
+		return clipped
+	}
+	out := make([][]*sheetsv4.CellData, len(clipped))
+	for i, row := range clipped {
+		start := colStart
+		if start > len(row) {
+			start = len(row)
+		}
+		stop := len(row)
+		if colEnd >= 0 && colEnd < stop {
+			stop = colEnd
+		}
+		if stop < start {
+			stop = start
+		}
+		out[i] = row[start:stop]
+	}
+	return out
+}
+
+// applyHeaderSkip moves rows[headerRow] to the front, as the header, then
+// drops the next skipRows rows that followed it. Rows before headerRow are
+// preamble and are discarded outright; they aren't charged against
+// skipRows.
+func applyHeaderSkip(rows [][]*sheetsv4.CellData, headerRow, skipRows int) ([][]*sheetsv4.CellData, error) {
+	if headerRow == 0 && skipRows == 0 {
+		return rows, nil
+	}
+	if headerRow < 0 || headerRow >= len(rows) {
+		return nil, fmt.Errorf("invalid -header-row %d for sheet with %d rows", headerRow, len(rows))
+	}
+	header := rows[headerRow]
+	body := rows[headerRow+1:]
+	if skipRows > len(body) {
+		skipRows = len(body)
+	}
+	body = body[skipRows:]
+
+	out := make([][]*sheetsv4.CellData, 0, len(body)+1)
+	out = append(out, header)
+	out = append(out, body...)
+	return out, nil
+}
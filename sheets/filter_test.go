@@ -0,0 +1,192 @@
+package sheets
+
+import (
+	"testing"
+
+	sheetsv4 "google.golang.org/api/sheets/v4"
+)
+
+func TestParseA1Range(t *testing.T) {
+	cases := []struct {
+		spec                                    string
+		rowStart, colStart, rowEnd, colEnd, err int
+	}{
+		{"", 0, 0, -1, -1, 0},
+		{"B2:F", 1, 1, -1, 6, 0},
+		{"A1:C10", 0, 0, 10, 3, 0},
+		{"B2:B2", 1, 1, 2, 2, 0},
+		{"not a range", 0, 0, 0, 0, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.spec, func(t *testing.T) {
+			rowStart, colStart, rowEnd, colEnd, err := parseA1Range(tc.spec)
+			if (err != nil) != (tc.err != 0) {
+				t.Fatalf("parseA1Range(%q) err = %v", tc.spec, err)
+			}
+			if err != nil {
+				return
+			}
+			if rowStart != tc.rowStart || colStart != tc.colStart || rowEnd != tc.rowEnd || colEnd != tc.colEnd {
+				t.Errorf("parseA1Range(%q) = (%d, %d, %d, %d), want (%d, %d, %d, %d)",
+					tc.spec, rowStart, colStart, rowEnd, colEnd,
+					tc.rowStart, tc.colStart, tc.rowEnd, tc.colEnd)
+			}
+		})
+	}
+}
+
+func TestColLetterIndex(t *testing.T) {
+	cases := map[string]int{
+		"A":  0,
+		"B":  1,
+		"Z":  25,
+		"AA": 26,
+		"AB": 27,
+	}
+	for letters, want := range cases {
+		if got := colLetterIndex(letters); got != want {
+			t.Errorf("colLetterIndex(%q) = %d, want %d", letters, got, want)
+		}
+	}
+}
+
+func cellRow(values ...string) []*sheetsv4.CellData {
+	row := make([]*sheetsv4.CellData, len(values))
+	for i, v := range values {
+		row[i] = &sheetsv4.CellData{FormattedValue: v}
+	}
+	return row
+}
+
+func rowValues(row []*sheetsv4.CellData) []string {
+	values := make([]string, len(row))
+	for i, cell := range row {
+		values[i] = cell.FormattedValue
+	}
+	return values
+}
+
+func TestClipCells(t *testing.T) {
+	rows := [][]*sheetsv4.CellData{
+		cellRow("a1", "b1", "c1"),
+		cellRow("a2", "b2", "c2"),
+		cellRow("a3", "b3", "c3"),
+	}
+
+	t.Run("no clip", func(t *testing.T) {
+		got := clipCells(rows, 0, 0, -1, -1)
+		if len(got) != 3 || rowValues(got[0])[0] != "a1" {
+			t.Errorf("clipCells = %v", got)
+		}
+	})
+
+	t.Run("row and column offset", func(t *testing.T) {
+		got := clipCells(rows, 1, 1, -1, -1)
+		if len(got) != 2 {
+			t.Fatalf("got %d rows, want 2", len(got))
+		}
+		if vs := rowValues(got[0]); len(vs) != 2 || vs[0] != "b2" {
+			t.Errorf("clipped first row = %v", vs)
+		}
+	})
+
+	t.Run("clamped bounds", func(t *testing.T) {
+		got := clipCells(rows, 5, 0, -1, -1)
+		if len(got) != 0 {
+			t.Errorf("clipCells past the end = %v, want empty", got)
+		}
+	})
+
+	t.Run("column end clips width", func(t *testing.T) {
+		got := clipCells(rows, 0, 0, -1, 2)
+		for _, row := range got {
+			if len(row) != 2 {
+				t.Errorf("row = %v, want width 2", rowValues(row))
+			}
+		}
+	})
+}
+
+func TestApplyHeaderSkip(t *testing.T) {
+	rows := [][]*sheetsv4.CellData{
+		cellRow("preamble"),
+		cellRow("h1", "h2"),
+		cellRow("skip1"),
+		cellRow("skip2"),
+		cellRow("body1"),
+	}
+
+	t.Run("defaults are a no-op", func(t *testing.T) {
+		got, err := applyHeaderSkip(rows, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(rows) {
+			t.Errorf("got %d rows, want %d", len(got), len(rows))
+		}
+	})
+
+	t.Run("header row and skip rows", func(t *testing.T) {
+		got, err := applyHeaderSkip(rows, 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("got %d rows, want 2", len(got))
+		}
+		if vs := rowValues(got[0]); vs[0] != "h1" {
+			t.Errorf("header = %v, want h1/h2", vs)
+		}
+		if vs := rowValues(got[1]); vs[0] != "body1" {
+			t.Errorf("body = %v, want body1", vs)
+		}
+	})
+
+	t.Run("out of range header row", func(t *testing.T) {
+		if _, err := applyHeaderSkip(rows, len(rows), 0); err == nil {
+			t.Error("expected an error for a header row past the end")
+		}
+	})
+
+	t.Run("skip rows clamped to remaining body", func(t *testing.T) {
+		got, err := applyHeaderSkip(rows, 1, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 {
+			t.Errorf("got %d rows, want 1 (header only)", len(got))
+		}
+	})
+}
+
+// TestClipSheetOffset is a regression test for the -range B2:F push bug:
+// clipSheet must keep reporting a clip rooted at B2, not silently widen
+// back out to the whole sheet, so callers that need the offset (pushRows)
+// can reconstruct it via parseA1Range independently of the clipped rows
+// themselves.
+func TestClipSheetOffset(t *testing.T) {
+	rows := [][]*sheetsv4.CellData{
+		cellRow("a1", "b1", "c1", "d1"),
+		cellRow("a2", "b2", "c2", "d2"),
+		cellRow("a3", "b3", "c3", "d3"),
+	}
+	c := &Config{RangeClip: "B2:C"}
+	got, err := c.clipSheet(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d rows, want 2", len(got))
+	}
+	if vs := rowValues(got[0]); len(vs) != 2 || vs[0] != "b2" {
+		t.Errorf("clipped sheet first row = %v, want [b2 c2]", vs)
+	}
+
+	rowStart, colStart, _, _, err := parseA1Range(c.RangeClip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rowStart != 1 || colStart != 1 {
+		t.Errorf("parseA1Range(%q) offset = (%d, %d), want (1, 1)", c.RangeClip, rowStart, colStart)
+	}
+}
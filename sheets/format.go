@@ -0,0 +1,356 @@
+package sheets
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// Formatter renders a sheet's rows, with rows[0] treated as the header row,
+// to some on-disk representation selectable with -format.
+type Formatter interface {
+	// Ext is the file extension, including the leading dot, for files
+	// written in this format.
+	Ext() string
+	// ContentType is the Content-Type header used when uploading files in
+	// this format.
+	ContentType() string
+	// Format writes rows to w. rows[0] is the header row.
+	Format(w io.Writer, rows [][]Cell) error
+}
+
+// formatterNames are the valid values for the -format flag, in the order
+// they're listed in its usage message.
+var formatterNames = []string{"csv", "json", "ndjson", "parquet"}
+
+func formatterFor(name string, useCRLF bool) (Formatter, error) {
+	switch name {
+	case "csv":
+		return csvFormatter{useCRLF: useCRLF}, nil
+	case "json":
+		return jsonFormatter{}, nil
+	case "ndjson":
+		return ndjsonFormatter{}, nil
+	case "parquet":
+		return parquetFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("unknown -format %q; must be one of %v", name, formatterNames)
+	}
+}
+
+// formatsFlag implements flag.Value so -format can be repeated on the
+// command line to select more than one output format.
+type formatsFlag struct {
+	formats *[]string
+}
+
+func (f *formatsFlag) String() string {
+	if f.formats == nil {
+		return ""
+	}
+	return strings.Join(*f.formats, ",")
+}
+
+func (f *formatsFlag) Set(s string) error {
+	if _, err := formatterFor(s, false); err != nil {
+		return err
+	}
+	*f.formats = append(*f.formats, s)
+	return nil
+}
+
+type csvFormatter struct {
+	useCRLF bool
+}
+
+func (csvFormatter) Ext() string         { return ".csv" }
+func (csvFormatter) ContentType() string { return "text/csv" }
+
+func (f csvFormatter) Format(w io.Writer, rows [][]Cell) (err error) {
+	cw := csv.NewWriter(w)
+	cw.UseCRLF = f.useCRLF
+	defer cw.Flush()
+	defer deferClose(&err, cw.Error)
+
+	for _, row := range rows {
+		if err = cw.Write(cellValues(row)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cellValues(row []Cell) []string {
+	values := make([]string, len(row))
+	for i, cell := range row {
+		values[i] = cell.Value
+	}
+	return values
+}
+
+type jsonFormatter struct{}
+
+func (jsonFormatter) Ext() string         { return ".json" }
+func (jsonFormatter) ContentType() string { return "application/json" }
+
+func (jsonFormatter) Format(w io.Writer, rows [][]Cell) error {
+	return json.NewEncoder(w).Encode(keyedRows(rows))
+}
+
+type ndjsonFormatter struct{}
+
+func (ndjsonFormatter) Ext() string         { return ".ndjson" }
+func (ndjsonFormatter) ContentType() string { return "application/x-ndjson" }
+
+func (ndjsonFormatter) Format(w io.Writer, rows [][]Cell) error {
+	enc := json.NewEncoder(w)
+	for _, row := range keyedRows(rows) {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyedRows keys every row after the header (rows[0]) by that header, for
+// the JSON and NDJSON formatters. A row gets a "_meta" key, itself keyed by
+// column name, for any cell that has a hyperlink or note.
+func keyedRows(rows [][]Cell) []map[string]interface{} {
+	if len(rows) == 0 {
+		return nil
+	}
+	header := cellValues(rows[0])
+	out := make([]map[string]interface{}, 0, len(rows)-1)
+	for _, record := range rows[1:] {
+		row := make(map[string]interface{}, len(header)+1)
+		meta := make(map[string]map[string]string)
+		for i, key := range header {
+			var cell Cell
+			if i < len(record) {
+				cell = record[i]
+			}
+			row[key] = cell.Value
+			if cell.Hyperlink != "" || cell.Note != "" {
+				m := make(map[string]string, 2)
+				if cell.Hyperlink != "" {
+					m["hyperlink"] = cell.Hyperlink
+				}
+				if cell.Note != "" {
+					m["note"] = cell.Note
+				}
+				meta[key] = m
+			}
+		}
+		if len(meta) > 0 {
+			row["_meta"] = meta
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+type parquetFormatter struct{}
+
+func (parquetFormatter) Ext() string         { return ".parquet" }
+func (parquetFormatter) ContentType() string { return "application/vnd.apache.parquet" }
+
+// parquetColumn is a column's inferred Parquet type, detected by scanning
+// every value in the column.
+type parquetColumn string
+
+const (
+	parquetString    parquetColumn = "string"
+	parquetInt       parquetColumn = "int"
+	parquetFloat     parquetColumn = "float"
+	parquetBool      parquetColumn = "bool"
+	parquetTimestamp parquetColumn = "timestamp"
+)
+
+func (parquetFormatter) Format(w io.Writer, rows [][]Cell) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	header := cellValues(rows[0])
+	body := make([][]string, len(rows)-1)
+	for i, row := range rows[1:] {
+		body[i] = cellValues(row)
+	}
+
+	names := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	columns := make([]parquetColumn, len(header))
+	for col, name := range header {
+		names[col] = uniqueName(slugify(name, col), seen)
+		columns[col] = inferParquetColumn(columnValues(body, col))
+	}
+
+	schema, err := parquetSchema(names, columns)
+	if err != nil {
+		return fmt.Errorf("could not build Parquet schema: %v", err)
+	}
+
+	pw, err := writer.NewJSONWriterFromWriter(schema, w, int64(1))
+	if err != nil {
+		return fmt.Errorf("could not create Parquet writer: %v", err)
+	}
+	for _, record := range body {
+		row := make(map[string]interface{}, len(names))
+		for col, name := range names {
+			var cell string
+			if col < len(record) {
+				cell = record[col]
+			}
+			row[name] = parquetValue(columns[col], cell)
+		}
+		b, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if err = pw.Write(string(b)); err != nil {
+			return fmt.Errorf("could not write Parquet row: %v", err)
+		}
+	}
+	return pw.WriteStop()
+}
+
+func columnValues(body [][]string, col int) []string {
+	values := make([]string, len(body))
+	for i, record := range body {
+		if col < len(record) {
+			values[i] = record[col]
+		}
+	}
+	return values
+}
+
+// inferParquetColumn scans every value in a column and picks the narrowest
+// type that all non-blank values satisfy, falling back to string. A column
+// with no non-blank values (including an empty column) also falls back to
+// string, rather than satisfying every type vacuously.
+func inferParquetColumn(values []string) parquetColumn {
+	isBool, isInt, isFloat, isTime := true, true, true, true
+	seenValue := false
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		seenValue = true
+		if v != "true" && v != "false" {
+			isBool = false
+		}
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			isInt = false
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			isFloat = false
+		}
+		if _, err := time.Parse(time.RFC3339, v); err != nil {
+			isTime = false
+		}
+	}
+	switch {
+	case !seenValue:
+		return parquetString
+	case isBool:
+		return parquetBool
+	case isInt:
+		return parquetInt
+	case isFloat:
+		return parquetFloat
+	case isTime:
+		return parquetTimestamp
+	default:
+		return parquetString
+	}
+}
+
+func parquetValue(col parquetColumn, cell string) interface{} {
+	switch col {
+	case parquetInt:
+		n, _ := strconv.ParseInt(cell, 10, 64)
+		return n
+	case parquetFloat:
+		f, _ := strconv.ParseFloat(cell, 64)
+		return f
+	case parquetBool:
+		return cell == "true"
+	case parquetTimestamp:
+		t, err := time.Parse(time.RFC3339, cell)
+		if err != nil {
+			return int64(0)
+		}
+		return t.UnixNano() / int64(time.Millisecond)
+	default:
+		return cell
+	}
+}
+
+// parquetSchema builds the JSON schema xitongsys/parquet-go's JSONWriter
+// expects, one REQUIRED field per column.
+func parquetSchema(names []string, columns []parquetColumn) (string, error) {
+	type field struct {
+		Tag string `json:"Tag"`
+	}
+	fields := make([]field, len(names))
+	for i, name := range names {
+		var tag string
+		switch columns[i] {
+		case parquetInt:
+			tag = fmt.Sprintf("name=%s, type=INT64, repetitiontype=REQUIRED", name)
+		case parquetFloat:
+			tag = fmt.Sprintf("name=%s, type=DOUBLE, repetitiontype=REQUIRED", name)
+		case parquetBool:
+			tag = fmt.Sprintf("name=%s, type=BOOLEAN, repetitiontype=REQUIRED", name)
+		case parquetTimestamp:
+			tag = fmt.Sprintf("name=%s, type=INT64, convertedtype=TIMESTAMP_MILLIS, repetitiontype=REQUIRED", name)
+		default:
+			tag = fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED", name)
+		}
+		fields[i] = field{Tag: tag}
+	}
+	schema := struct {
+		Tag    string  `json:"Tag"`
+		Fields []field `json:"Fields"`
+	}{
+		Tag:    "name=parquet_go_root, repetitiontype=REQUIRED",
+		Fields: fields,
+	}
+	b, err := json.Marshal(schema)
+	return string(b), err
+}
+
+// slugify turns a header cell into a valid Parquet column name, falling
+// back to col_<i> if nothing alphanumeric survives.
+func slugify(s string, col int) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	name := strings.Trim(sb.String(), "_")
+	if name == "" {
+		name = fmt.Sprintf("col_%d", col)
+	}
+	return name
+}
+
+// uniqueName disambiguates columns that slugify to the same name (e.g.
+// duplicate or blank headers).
+func uniqueName(name string, seen map[string]bool) string {
+	candidate := name
+	for i := 2; seen[candidate]; i++ {
+		candidate = fmt.Sprintf("%s_%d", name, i)
+	}
+	seen[candidate] = true
+	return candidate
+}
@@ -0,0 +1,95 @@
+package sheets
+
+import "testing"
+
+func TestInferParquetColumn(t *testing.T) {
+	cases := []struct {
+		name   string
+		values []string
+		want   parquetColumn
+	}{
+		{"empty column", nil, parquetString},
+		{"all blank", []string{"", ""}, parquetString},
+		{"ints", []string{"1", "-2", ""}, parquetInt},
+		{"floats", []string{"1.5", "2", ""}, parquetFloat},
+		{"bools", []string{"true", "false", ""}, parquetBool},
+		{"timestamps", []string{"2020-01-02T15:04:05Z", ""}, parquetTimestamp},
+		{"mixed falls back to string", []string{"1", "hello"}, parquetString},
+		{"single non-numeric string", []string{"hello"}, parquetString},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := inferParquetColumn(tc.values); got != tc.want {
+				t.Errorf("inferParquetColumn(%v) = %v, want %v", tc.values, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	cases := []struct {
+		name string
+		s    string
+		col  int
+		want string
+	}{
+		{"simple", "Name", 0, "name"},
+		{"spaces and punctuation", "First Name!", 1, "first_name"},
+		{"leading/trailing punctuation trimmed", "  %Col%  ", 2, "col"},
+		{"nothing alphanumeric survives", "###", 3, "col_3"},
+		{"empty", "", 4, "col_4"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := slugify(tc.s, tc.col); got != tc.want {
+				t.Errorf("slugify(%q, %d) = %q, want %q", tc.s, tc.col, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	seen := make(map[string]bool)
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, uniqueName("col", seen))
+	}
+	want := []string{"col", "col_2", "col_3"}
+	for i, name := range got {
+		if name != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestKeyedRows(t *testing.T) {
+	rows := [][]Cell{
+		{{Value: "name"}, {Value: "note"}},
+		{{Value: "a"}, {Value: "x", Note: "flagged"}},
+		{{Value: "b"}},
+	}
+	got := keyedRows(rows)
+	if len(got) != 2 {
+		t.Fatalf("got %d rows, want 2", len(got))
+	}
+	if got[0]["name"] != "a" || got[0]["note"] != "x" {
+		t.Errorf("row 0 = %v", got[0])
+	}
+	meta, ok := got[0]["_meta"].(map[string]map[string]string)
+	if !ok || meta["note"]["note"] != "flagged" {
+		t.Errorf("row 0 missing expected _meta: %v", got[0])
+	}
+	if _, ok := got[1]["_meta"]; ok {
+		t.Errorf("row 1 should have no _meta, got %v", got[1])
+	}
+	if got[1]["note"] != "" {
+		t.Errorf("row 1 short of cells should default to empty value, got %v", got[1]["note"])
+	}
+}
+
+func TestKeyedRowsEmpty(t *testing.T) {
+	if got := keyedRows(nil); got != nil {
+		t.Errorf("keyedRows(nil) = %v, want nil", got)
+	}
+}
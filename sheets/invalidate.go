@@ -0,0 +1,380 @@
+package sheets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudfront"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+// Invalidator purges paths from a CDN after they've been uploaded.
+type Invalidator interface {
+	Invalidate(ctx context.Context, paths []string) error
+}
+
+// cdnsFlag implements flag.Value so -cdn can be repeated to invalidate more
+// than one CDN.
+type cdnsFlag struct {
+	cdns *[]string
+}
+
+func (f *cdnsFlag) String() string {
+	if f.cdns == nil {
+		return ""
+	}
+	return strings.Join(*f.cdns, ",")
+}
+
+func (f *cdnsFlag) Set(s string) error {
+	if _, err := parseInvalidator(s, nil); err != nil {
+		return err
+	}
+	*f.cdns = append(*f.cdns, s)
+	return nil
+}
+
+// invalidators builds the Invalidator for -dist (if set, as shorthand for
+// cloudfront://) followed by one for each -cdn URL.
+func (c *Config) invalidators() ([]Invalidator, error) {
+	urls := make([]string, 0, len(c.CDNs)+1)
+	if c.CloudFrontDist != "" {
+		urls = append(urls, "cloudfront://"+c.CloudFrontDist)
+	}
+	urls = append(urls, c.CDNs...)
+
+	invalidators := make([]Invalidator, 0, len(urls))
+	for _, raw := range urls {
+		inv, err := parseInvalidator(raw, c.Logger)
+		if err != nil {
+			return nil, err
+		}
+		invalidators = append(invalidators, inv)
+	}
+	return invalidators, nil
+}
+
+// invalidateAll runs every configured Invalidator in parallel and returns
+// the first error, if any.
+func (c *Config) invalidateAll(ctx context.Context, paths []string) (err error) {
+	ctx, span := tracer.Start(ctx, "invalidateAll", trace.WithAttributes(
+		attribute.Int("paths", len(paths)),
+	))
+	defer endSpan(span, &err)
+
+	invalidators, err := c.invalidators()
+	if err != nil {
+		return err
+	}
+	if len(invalidators) == 0 {
+		return nil
+	}
+
+	errCh := make(chan error, len(invalidators))
+	for _, inv := range invalidators {
+		inv := inv
+		go func() {
+			errCh <- inv.Invalidate(ctx, paths)
+		}()
+	}
+	var firstErr error
+	for range invalidators {
+		if err := <-errCh; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// parseInvalidator parses a -cdn URL such as cloudfront://<dist-id>,
+// fastly://<service-id>?token=..., cloudflare://<zone-id>?token=..., or
+// gccdn://<url-map>?project=...&token=... into an Invalidator. logger may be
+// nil when parseInvalidator is only being used to validate a flag value.
+func parseInvalidator(rawurl string, logger *slog.Logger) (Invalidator, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -cdn %q: %v", rawurl, err)
+	}
+	switch u.Scheme {
+	case "cloudfront":
+		return &cloudFrontInvalidator{distID: u.Host, logger: logger}, nil
+	case "fastly":
+		return &fastlyInvalidator{serviceID: u.Host, token: u.Query().Get("token"), logger: logger}, nil
+	case "cloudflare":
+		origin := strings.TrimSuffix(u.Query().Get("origin"), "/")
+		if origin == "" {
+			return nil, fmt.Errorf("invalid -cdn %q: cloudflare:// requires an ?origin=https://example.com query param, since Cloudflare's purge_cache API takes full URLs, not bare paths", rawurl)
+		}
+		return &cloudflareInvalidator{zoneID: u.Host, token: u.Query().Get("token"), origin: origin, logger: logger}, nil
+	case "gccdn":
+		return &googleCDNInvalidator{
+			urlMap:  u.Host,
+			project: u.Query().Get("project"),
+			token:   u.Query().Get("token"),
+			logger:  logger,
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid -cdn %q: unknown scheme %q", rawurl, u.Scheme)
+	}
+}
+
+// retry calls fn up to attempts times, doubling backoff after each failure,
+// and returns the last error if every attempt fails.
+func retry(ctx context.Context, attempts int, backoff time.Duration, fn func() error) error {
+	var err error
+	for i := 0; i < attempts; i++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if i == attempts-1 {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+func normalizePaths(paths []string) []string {
+	out := make([]string, len(paths))
+	for i, p := range paths {
+		if !strings.HasPrefix(p, "/") {
+			p = "/" + p
+		}
+		p = url.PathEscape(p)
+		p = strings.ReplaceAll(p, "%2F", "/")
+		out[i] = p
+	}
+	return out
+}
+
+func chunkStrings(items []string, size int) [][]string {
+	var chunks [][]string
+	for len(items) > 0 {
+		n := size
+		if n > len(items) {
+			n = len(items)
+		}
+		chunks = append(chunks, items[:n])
+		items = items[n:]
+	}
+	return chunks
+}
+
+// cloudFrontBatchLimit is the maximum number of paths CloudFront accepts in
+// a single invalidation batch.
+const cloudFrontBatchLimit = 3000
+
+type cloudFrontInvalidator struct {
+	distID string
+	logger *slog.Logger
+}
+
+func (inv *cloudFrontInvalidator) Invalidate(ctx context.Context, paths []string) error {
+	paths = normalizePaths(paths)
+	cf := cloudfront.New(session.Must(session.NewSession()))
+	for _, batch := range chunkStrings(paths, cloudFrontBatchLimit) {
+		inv.logger.Info("invalidating CloudFront paths", "dist_id", inv.distID, "paths", batch)
+		batch := batch
+		err := retry(ctx, 3, time.Second, func() error {
+			callerReference := fmt.Sprintf("%d", time.Now().UnixNano())
+			_, err := cf.CreateInvalidationWithContext(ctx, &cloudfront.CreateInvalidationInput{
+				DistributionId: &inv.distID,
+				InvalidationBatch: &cloudfront.InvalidationBatch{
+					CallerReference: &callerReference,
+					Paths:           makeCFPaths(batch),
+				},
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("could not invalidate CloudFront distribution %s: %v", inv.distID, err)
+		}
+	}
+	return nil
+}
+
+func makeCFPaths(paths []string) *cloudfront.Paths {
+	items := make([]*string, len(paths))
+	for i := range paths {
+		items[i] = &paths[i]
+	}
+	quantity := int64(len(items))
+	return &cloudfront.Paths{
+		Items:    items,
+		Quantity: &quantity,
+	}
+}
+
+// surrogateKeyMetadata returns the blob.WriterOptions.Metadata to attach to
+// an uploaded file so fastlyInvalidator's purges land on it. Cloud storage
+// metadata isn't itself an HTTP response header; the CDN's origin must be
+// configured to mirror this "surrogate-key" metadata entry into a
+// Surrogate-Key response header (e.g. an S3 origin's x-amz-meta-surrogate-key
+// forwarded by the CDN) for Fastly purges to match anything.
+func surrogateKeyMetadata(fullpath string) map[string]string {
+	return map[string]string{"surrogate-key": normalizePaths([]string{fullpath})[0]}
+}
+
+// fastlyBatchLimit is Fastly's limit on surrogate keys per bulk purge
+// request.
+const fastlyBatchLimit = 256
+
+type fastlyInvalidator struct {
+	serviceID string
+	token     string
+	logger    *slog.Logger
+}
+
+func (inv *fastlyInvalidator) Invalidate(ctx context.Context, paths []string) error {
+	keys := normalizePaths(paths)
+	for _, batch := range chunkStrings(keys, fastlyBatchLimit) {
+		inv.logger.Info("purging Fastly paths", "service_id", inv.serviceID, "paths", batch)
+		batch := batch
+		err := retry(ctx, 3, time.Second, func() error {
+			body, err := json.Marshal(struct {
+				SurrogateKeys []string `json:"surrogate_keys"`
+			}{batch})
+			if err != nil {
+				return err
+			}
+			endpoint := fmt.Sprintf("https://api.fastly.com/service/%s/purge", inv.serviceID)
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Fastly-Key", inv.token)
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", "application/json")
+			return doAndCheck(req)
+		})
+		if err != nil {
+			return fmt.Errorf("could not purge Fastly service %s: %v", inv.serviceID, err)
+		}
+	}
+	return nil
+}
+
+// cloudflareBatchLimit is Cloudflare's limit on files per purge_cache
+// request.
+const cloudflareBatchLimit = 30
+
+type cloudflareInvalidator struct {
+	zoneID string
+	token  string
+	// origin is prepended to each path to build the fully-qualified URLs
+	// Cloudflare's purge_cache-by-URL API requires; it has no concept of a
+	// bucket-relative path on its own.
+	origin string
+	logger *slog.Logger
+}
+
+func (inv *cloudflareInvalidator) Invalidate(ctx context.Context, paths []string) error {
+	files := inv.fileURLs(paths)
+	for _, batch := range chunkStrings(files, cloudflareBatchLimit) {
+		inv.logger.Info("purging Cloudflare paths", "zone_id", inv.zoneID, "paths", batch)
+		batch := batch
+		err := retry(ctx, 3, time.Second, func() error {
+			body, err := json.Marshal(struct {
+				Files []string `json:"files"`
+			}{batch})
+			if err != nil {
+				return err
+			}
+			endpoint := fmt.Sprintf("https://api.cloudflare.com/client/v4/zones/%s/purge_cache", inv.zoneID)
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+inv.token)
+			req.Header.Set("Content-Type", "application/json")
+			return doAndCheck(req)
+		})
+		if err != nil {
+			return fmt.Errorf("could not purge Cloudflare zone %s: %v", inv.zoneID, err)
+		}
+	}
+	return nil
+}
+
+// fileURLs builds the fully-qualified URLs Cloudflare's purge_cache-by-URL
+// API expects, by joining inv.origin with each bucket-relative path.
+func (inv *cloudflareInvalidator) fileURLs(paths []string) []string {
+	normalized := normalizePaths(paths)
+	files := make([]string, len(normalized))
+	for i, p := range normalized {
+		files[i] = inv.origin + p
+	}
+	return files
+}
+
+func doAndCheck(req *http.Request) error {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: unexpected status %s", req.URL, resp.Status)
+	}
+	return nil
+}
+
+// googleCDNInvalidator invalidates one path at a time, since
+// UrlMaps.InvalidateCache takes a single CacheInvalidationRule per call.
+type googleCDNInvalidator struct {
+	urlMap  string
+	project string
+	// token, if set, authenticates with a static OAuth2 access token instead
+	// of the ambient application-default credentials.
+	token  string
+	logger *slog.Logger
+}
+
+func (inv *googleCDNInvalidator) Invalidate(ctx context.Context, paths []string) error {
+	var opt option.ClientOption
+	if inv.token != "" {
+		opt = option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: inv.token}))
+	} else {
+		client, err := google.DefaultClient(ctx, compute.ComputeScope)
+		if err != nil {
+			return fmt.Errorf("could not find Google credentials: %v", err)
+		}
+		opt = option.WithHTTPClient(client)
+	}
+	svc, err := compute.NewService(ctx, opt)
+	if err != nil {
+		return fmt.Errorf("could not start Google Compute client: %v", err)
+	}
+	for _, p := range normalizePaths(paths) {
+		p := p
+		inv.logger.Info("invalidating path in Google Cloud CDN", "url_map", inv.urlMap, "path", p)
+		err := retry(ctx, 3, time.Second, func() error {
+			_, err := svc.UrlMaps.InvalidateCache(inv.project, inv.urlMap, &compute.CacheInvalidationRule{
+				Path: p,
+			}).Context(ctx).Do()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("could not invalidate %q in url map %s: %v", p, inv.urlMap, err)
+		}
+	}
+	return nil
+}
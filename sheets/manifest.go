@@ -0,0 +1,87 @@
+package sheets
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"time"
+
+	"gocloud.dev/blob"
+	sheetsv4 "google.golang.org/api/sheets/v4"
+)
+
+// ManifestEntry describes one file written for a sheet during a pull, as
+// recorded in the index.json manifest.
+type ManifestEntry struct {
+	Sheet   string   `json:"sheet"`
+	Path    string   `json:"path"`
+	Bytes   int      `json:"bytes"`
+	MD5     string   `json:"md5"`
+	Rows    int      `json:"rows"`
+	Columns int      `json:"columns"`
+	Schema  []string `json:"schema"`
+}
+
+// newManifestEntry builds the ManifestEntry for one file written for sheet.
+func newManifestEntry(sheet *sheetsv4.Sheet, fullpath string, size int, sum []byte, rows, columns int, schema []string) ManifestEntry {
+	return ManifestEntry{
+		Sheet:   sheet.Properties.Title,
+		Path:    fullpath,
+		Bytes:   size,
+		MD5:     md5Hex(sum),
+		Rows:    rows,
+		Columns: columns,
+		Schema:  schema,
+	}
+}
+
+// Manifest is the top-level index.json document written after a pull,
+// summarizing every file written to the bucket in that run.
+type Manifest struct {
+	Title       string          `json:"title"`
+	SheetID     string          `json:"sheet_id"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Sheets      []ManifestEntry `json:"sheets"`
+}
+
+// sheetSchema reports the row count, column count, and inferred per-column
+// type of records, treating records[0] as the header.
+func sheetSchema(records [][]Cell) (rows, columns int, schema []string) {
+	if len(records) == 0 {
+		return 0, 0, nil
+	}
+	header := cellValues(records[0])
+	body := make([][]string, len(records)-1)
+	for i, record := range records[1:] {
+		body[i] = cellValues(record)
+	}
+	schema = make([]string, len(header))
+	for col := range header {
+		schema[col] = string(inferParquetColumn(columnValues(body, col)))
+	}
+	return len(body), len(header), schema
+}
+
+// writeManifest marshals m as JSON and writes it to c.Manifest under dir,
+// returning the full path written so it can be included in CDN
+// invalidation.
+func (c *Config) writeManifest(ctx context.Context, b *blob.Bucket, dir string, m Manifest, opts *blob.WriterOptions) (string, error) {
+	fullpath := path.Join(dir, c.Manifest)
+	body, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	writeOpts := *opts
+	writeOpts.ContentType = "application/json"
+	writeOpts.Metadata = surrogateKeyMetadata(fullpath)
+	c.Logger.Info("writing manifest", "path", fullpath, "bytes", len(body), "sheets", len(m.Sheets))
+	if err := b.WriteAll(ctx, fullpath, body, &writeOpts); err != nil {
+		return "", err
+	}
+	return fullpath, nil
+}
+
+func md5Hex(sum []byte) string {
+	return hex.EncodeToString(sum)
+}
@@ -0,0 +1,394 @@
+package sheets
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"path"
+	"strings"
+
+	"github.com/henvic/ctxsignal"
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+	"google.golang.org/api/option"
+	sheetsv4 "google.golang.org/api/sheets/v4"
+)
+
+// execPush reads the CSVs previously written by execPull out of the bucket
+// and writes any that have changed back to the source Google Sheet. It also
+// creates tabs, via the -manifest index, for any sheet the bucket knows
+// about that the live Spreadsheet doesn't have yet.
+func (c *Config) execPush(ctx context.Context) (err error) {
+	pt, err := template.New("path").Parse(c.PathTemplate)
+	if err != nil {
+		return fmt.Errorf("path template problem: %v", err)
+	}
+	ft, err := template.New("file").Parse(c.FileTemplate)
+	if err != nil {
+		return fmt.Errorf("file path template problem: %v", err)
+	}
+
+	ctx, cancel := ctxsignal.WithTermination(ctx)
+	defer cancel()
+
+	c.Logger.Info("opening cloud storage", "bucket_url", c.BucketURL)
+	b, err := blob.OpenBucket(ctx, c.BucketURL)
+	if err != nil {
+		return fmt.Errorf("could not open bucket: %v", err)
+	}
+	defer deferClose(&err, b.Close)
+
+	client, err := c.googleClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.Logger.Info("connecting to Google Sheets", "sheet_id", c.SheetID)
+	svc, err := sheetsv4.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return fmt.Errorf("could not start Google Sheets client: %v", err)
+	}
+
+	doc, err := c.fetchSpreadsheet(ctx, svc)
+	if err != nil {
+		return err
+	}
+
+	c.Logger.Info("fetched spreadsheet", "sheet_id", c.SheetID, "sheet_title", doc.Properties.Title)
+
+	var dirBuf strings.Builder
+	if err = pt.Execute(&dirBuf, doc); err != nil {
+		return fmt.Errorf("could not use path template: %v", err)
+	}
+	dir := dirBuf.String()
+
+	// known and usedIDs are captured from every sheet the Spreadsheet
+	// actually has, before -sheets/-only-visible narrow the set below, so
+	// pushNewSheets doesn't mistake a sheet that's merely out of scope for
+	// this run for one that needs to be created.
+	known := make(map[string]bool, len(doc.Sheets))
+	usedIDs := make(map[int64]bool, len(doc.Sheets))
+	for _, sheet := range doc.Sheets {
+		known[sheet.Properties.Title] = true
+		usedIDs[sheet.Properties.SheetId] = true
+	}
+
+	pushSheets, err := c.filterSheets(doc.Sheets)
+	if err != nil {
+		return err
+	}
+
+	for _, sheet := range pushSheets {
+		var sb strings.Builder
+		if err = ft.Execute(&sb, sheet); err != nil {
+			return fmt.Errorf("could not use file path template: %v", err)
+		}
+		fullpath := path.Join(dir, sb.String())
+
+		reqs, err := c.pushSheet(ctx, b, fullpath, sheet)
+		if err != nil {
+			return err
+		}
+		if len(reqs) == 0 {
+			continue
+		}
+
+		c.Logger.Info("pushing file to Google Sheets", "path", fullpath, "sheet_title", sheet.Properties.Title)
+		if _, err = svc.Spreadsheets.BatchUpdate(c.SheetID, &sheetsv4.BatchUpdateSpreadsheetRequest{
+			Requests: reqs,
+		}).Context(ctx).Do(); err != nil {
+			return fmt.Errorf("could not update %q: %v", sheet.Properties.Title, err)
+		}
+	}
+
+	if c.Manifest != "" {
+		if err := c.pushNewSheets(ctx, b, svc, dir, known, usedIDs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pushSheet compares the CSV stored at fullpath against sheet's current
+// contents run through the exact same -range/-header-row/-skip-rows/
+// -value-render pipeline uploadSheet used to produce it (clipSheet,
+// cellsFromRows, then the csv Formatter), so the comparison - and, if they
+// differ, the rewrite - line up with what's actually sitting in the
+// bucket instead of a reconstruction from the sheet's raw, unclipped data.
+// It returns no requests when the CSV is missing from the bucket or
+// already matches the sheet, mirroring the skip-if-unchanged logic in
+// uploadSheet.
+func (c *Config) pushSheet(ctx context.Context, b *blob.Bucket, fullpath string, sheet *sheetsv4.Sheet) ([]*sheetsv4.Request, error) {
+	c.Logger.Info("checking file against sheet", "path", fullpath, "bucket_url", c.BucketURL)
+	csvBytes, err := b.ReadAll(ctx, fullpath)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			c.Logger.Info("skipping file not in bucket", "path", fullpath, "skipped", true)
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not read %q: %v", fullpath, err)
+	}
+
+	clipped, err := c.clipSheet(flattenGrid(sheet.Data))
+	if err != nil {
+		return nil, err
+	}
+	records := cellsFromRows(clipped, c.ValueRender)
+
+	var currentCSV bytes.Buffer
+	if err := (csvFormatter{useCRLF: c.UseCRLF}).Format(&currentCSV, records); err != nil {
+		return nil, err
+	}
+
+	if md5.Sum(csvBytes) == md5.Sum(currentCSV.Bytes()) {
+		c.Logger.Info("skipping unchanged file", "path", fullpath, "skipped", true)
+		return nil, nil
+	}
+
+	rows, err := csv.NewReader(bytes.NewReader(csvBytes)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %q: %v", fullpath, err)
+	}
+
+	return c.pushRows(sheet, rows)
+}
+
+// pushRows builds the requests needed to write rows - the header row
+// followed by the body rows, exactly as clipSheet laid them out - back to
+// their true position in sheet, undoing -range's row/column offset and
+// -header-row/-skip-rows' vertical split. It grows the sheet's grid first
+// if rows no longer fits, then clears the rest of the clipped rectangle
+// (out to its previous extent) so that a CSV shrunk by editing doesn't
+// leave stale cells behind - all scoped to the clipped rectangle itself,
+// so cells -range/-header-row/-skip-rows excluded (column A left of a "B:F"
+// clip, rows skipped as preamble, etc.) are left untouched rather than
+// cleared.
+func (c *Config) pushRows(sheet *sheetsv4.Sheet, rows [][]string) ([]*sheetsv4.Request, error) {
+	rowStart, colStart, rowEnd, colEnd, err := parseA1Range(c.RangeClip)
+	if err != nil {
+		return nil, err
+	}
+
+	sheetID := sheet.Properties.SheetId
+	gridRows, gridCols := 0, 0
+	if gp := sheet.Properties.GridProperties; gp != nil {
+		gridRows, gridCols = int(gp.RowCount), int(gp.ColumnCount)
+	}
+
+	clipRowEnd := rowEnd
+	if clipRowEnd < 0 || clipRowEnd > gridRows {
+		clipRowEnd = gridRows
+	}
+	clipColEnd := colEnd
+	if clipColEnd < 0 || clipColEnd > gridCols {
+		clipColEnd = gridCols
+	}
+
+	headerRow := rowStart + c.HeaderRow
+	bodyRow := headerRow + 1 + c.SkipRows
+
+	var header []string
+	body := rows
+	if len(rows) > 0 {
+		header, body = rows[0], rows[1:]
+	}
+
+	width := clipColEnd - colStart
+	if width < 0 {
+		width = 0
+	}
+	if w := maxColumns(rows); w > width {
+		width = w
+	}
+	bodyHeight := clipRowEnd - bodyRow
+	if bodyHeight < 0 {
+		bodyHeight = 0
+	}
+	if len(body) > bodyHeight {
+		bodyHeight = len(body)
+	}
+
+	var reqs []*sheetsv4.Request
+	if needRows, needCols := bodyRow+bodyHeight, colStart+width; needRows > gridRows || needCols > gridCols {
+		if needRows > gridRows {
+			gridRows = needRows
+		}
+		if needCols > gridCols {
+			gridCols = needCols
+		}
+		reqs = append(reqs, &sheetsv4.Request{
+			UpdateSheetProperties: &sheetsv4.UpdateSheetPropertiesRequest{
+				Properties: &sheetsv4.SheetProperties{
+					SheetId: sheetID,
+					GridProperties: &sheetsv4.GridProperties{
+						RowCount:    int64(gridRows),
+						ColumnCount: int64(gridCols),
+					},
+				},
+				Fields: "gridProperties.rowCount,gridProperties.columnCount",
+			},
+		})
+	}
+
+	if len(rows) > 0 {
+		reqs = append(reqs, &sheetsv4.Request{
+			UpdateCells: &sheetsv4.UpdateCellsRequest{
+				Fields: "userEnteredValue",
+				Start: &sheetsv4.GridCoordinate{
+					SheetId:     sheetID,
+					RowIndex:    int64(headerRow),
+					ColumnIndex: int64(colStart),
+				},
+				Rows: padRowData([][]string{header}, 1, width),
+			},
+		})
+	}
+
+	if bodyHeight > 0 {
+		reqs = append(reqs, &sheetsv4.Request{
+			UpdateCells: &sheetsv4.UpdateCellsRequest{
+				Fields: "userEnteredValue",
+				Start: &sheetsv4.GridCoordinate{
+					SheetId:     sheetID,
+					RowIndex:    int64(bodyRow),
+					ColumnIndex: int64(colStart),
+				},
+				Rows: padRowData(body, bodyHeight, width),
+			},
+		})
+	}
+
+	return reqs, nil
+}
+
+// maxColumns reports the width of the widest record in rows.
+func maxColumns(rows [][]string) int {
+	cols := 0
+	for _, record := range rows {
+		if len(record) > cols {
+			cols = len(record)
+		}
+	}
+	return cols
+}
+
+// padRowData builds the RowData for an UpdateCellsRequest spanning
+// rowCount x colCount: cells within a CSV record keep that record's value,
+// and every cell beyond it - whether because a row or column shrank - is
+// written as an explicitly empty CellData so the update clears it instead
+// of leaving stale data behind.
+func padRowData(rows [][]string, rowCount, colCount int) []*sheetsv4.RowData {
+	rowData := make([]*sheetsv4.RowData, rowCount)
+	for i := 0; i < rowCount; i++ {
+		var record []string
+		if i < len(rows) {
+			record = rows[i]
+		}
+		values := make([]*sheetsv4.CellData, colCount)
+		for j := 0; j < colCount; j++ {
+			if j < len(record) {
+				field := record[j]
+				values[j] = &sheetsv4.CellData{
+					UserEnteredValue: &sheetsv4.ExtendedValue{StringValue: &field},
+				}
+				continue
+			}
+			values[j] = &sheetsv4.CellData{}
+		}
+		rowData[i] = &sheetsv4.RowData{Values: values}
+	}
+	return rowData
+}
+
+// pushNewSheets creates a tab, via AddSheet, for every sheet recorded in
+// dir's index.json manifest that known doesn't already have, then populates
+// it with the bucket's CSV for that sheet via AppendCells. The manifest is
+// the only place this tool records what sheet a CSV belongs to, since a
+// filename on its own can't generally be inverted back through -filename's
+// template to recover a sheet that no longer exists in the Spreadsheet.
+func (c *Config) pushNewSheets(ctx context.Context, b *blob.Bucket, svc *sheetsv4.Service, dir string, known map[string]bool, usedIDs map[int64]bool) error {
+	manifestPath := path.Join(dir, c.Manifest)
+	body, err := b.ReadAll(ctx, manifestPath)
+	if err != nil {
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			c.Logger.Info("skipping new sheet detection, no manifest in bucket", "path", manifestPath, "skipped", true)
+			return nil
+		}
+		return fmt.Errorf("could not read %q: %v", manifestPath, err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return fmt.Errorf("could not parse %q: %v", manifestPath, err)
+	}
+
+	for _, entry := range m.Sheets {
+		if known[entry.Sheet] || path.Ext(entry.Path) != ".csv" {
+			continue
+		}
+		known[entry.Sheet] = true
+
+		if err := c.pushNewSheet(ctx, b, svc, entry, nextSheetID(usedIDs)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextSheetID picks a SheetId not already in usedIDs and marks it used, so a
+// new sheet's ID is known up front instead of having to be read back from an
+// AddSheet reply.
+func nextSheetID(usedIDs map[int64]bool) int64 {
+	var id int64
+	for usedIDs[id] {
+		id++
+	}
+	usedIDs[id] = true
+	return id
+}
+
+// pushNewSheet adds a tab titled entry.Sheet with the given sheetID and
+// populates it with the CSV at entry.Path, as a single BatchUpdate call so
+// the add and the populate either both apply or neither does; pushing the
+// AddSheet reply's assigned ID into a second call would leave an empty tab
+// behind forever if that second call failed, since a later run would see
+// the tab in doc.Sheets and consider entry.Sheet already pushed.
+func (c *Config) pushNewSheet(ctx context.Context, b *blob.Bucket, svc *sheetsv4.Service, entry ManifestEntry, sheetID int64) error {
+	c.Logger.Info("adding sheet missing from spreadsheet", "sheet_title", entry.Sheet, "path", entry.Path)
+
+	csvBytes, err := b.ReadAll(ctx, entry.Path)
+	if err != nil {
+		return fmt.Errorf("could not read %q: %v", entry.Path, err)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(csvBytes)).ReadAll()
+	if err != nil {
+		return fmt.Errorf("could not parse %q: %v", entry.Path, err)
+	}
+
+	if _, err := svc.Spreadsheets.BatchUpdate(c.SheetID, &sheetsv4.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheetsv4.Request{
+			{
+				AddSheet: &sheetsv4.AddSheetRequest{
+					Properties: &sheetsv4.SheetProperties{
+						SheetId: sheetID,
+						Title:   entry.Sheet,
+					},
+				},
+			},
+			{
+				AppendCells: &sheetsv4.AppendCellsRequest{
+					SheetId: sheetID,
+					Fields:  "userEnteredValue",
+					Rows:    padRowData(rows, len(rows), maxColumns(rows)),
+				},
+			},
+		},
+	}).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("could not add and populate sheet %q: %v", entry.Sheet, err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package sheets
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer is used for every span sheets-uploader creates.
+var tracer = otel.Tracer(AppName)
+
+// initTracing configures the global OpenTelemetry tracer provider to export
+// spans over OTLP/gRPC to OTEL_EXPORTER_OTLP_ENDPOINT, when that environment
+// variable is set. It returns a shutdown func that flushes any buffered
+// spans; shutdown is a no-op when no endpoint is configured, in which case
+// spans are created against the default no-op tracer provider.
+func initTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	noop := func(context.Context) error { return nil }
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return noop, nil
+	}
+
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return noop, fmt.Errorf("could not create OTLP exporter: %v", err)
+	}
+	res, err := resource.New(ctx, resource.WithAttributes(semconv.ServiceName(AppName)))
+	if err != nil {
+		return noop, fmt.Errorf("could not build OpenTelemetry resource: %v", err)
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	tracer = tp.Tracer(AppName)
+	return tp.Shutdown, nil
+}
+
+// endSpan ends span, recording *err on it first if non-nil.
+func endSpan(span trace.Span, err *error) {
+	if err != nil && *err != nil {
+		span.RecordError(*err)
+	}
+	span.End()
+}